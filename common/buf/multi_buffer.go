@@ -0,0 +1,67 @@
+package buf
+
+import "github.com/sagernet/sing/common"
+
+// MultiBuffer is a vector of *Buffer handled as a single unit, letting
+// readers and writers batch several packets or chunks through one call
+// instead of making the round trip through bufio.Copy once per buffer.
+type MultiBuffer []*Buffer
+
+// NewMultiBuffer creates an empty MultiBuffer with room for a handful of
+// buffers before it needs to grow.
+func NewMultiBuffer() MultiBuffer {
+	return make(MultiBuffer, 0, 4)
+}
+
+// Len returns the total length of the data held across every buffer.
+func (mb MultiBuffer) Len() int {
+	var total int
+	for _, buffer := range mb {
+		total += buffer.Len()
+	}
+	return total
+}
+
+// Release releases every buffer it contains and truncates mb to zero length.
+func (mb *MultiBuffer) Release() {
+	for _, buffer := range *mb {
+		buffer.Release()
+	}
+	*mb = (*mb)[:0]
+}
+
+// SplitFirst removes and returns the first buffer, or nil if mb is empty.
+func (mb *MultiBuffer) SplitFirst() *Buffer {
+	if len(*mb) == 0 {
+		return nil
+	}
+	first := (*mb)[0]
+	*mb = (*mb)[1:]
+	return first
+}
+
+// SplitSize removes up to size bytes worth of buffers from the front of mb
+// and returns them as a new MultiBuffer, splitting the boundary buffer in
+// place if size falls in the middle of it.
+func (mb *MultiBuffer) SplitSize(size int) MultiBuffer {
+	if size <= 0 || len(*mb) == 0 {
+		return nil
+	}
+	var split MultiBuffer
+	remaining := size
+	for len(*mb) > 0 && remaining > 0 {
+		buffer := (*mb)[0]
+		if buffer.Len() <= remaining {
+			split = append(split, buffer)
+			*mb = (*mb)[1:]
+			remaining -= buffer.Len()
+			continue
+		}
+		head := NewSize(remaining)
+		common.Must1(head.Write(buffer.To(remaining)))
+		buffer.Advance(remaining)
+		split = append(split, head)
+		remaining = 0
+	}
+	return split
+}
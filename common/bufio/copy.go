@@ -57,6 +57,11 @@ func Copy(dst io.Writer, src io.Reader) (n int64, err error) {
 }
 
 func CopyExtended(dst N.ExtendedWriter, src N.ExtendedReader) (n int64, err error) {
+	if multiWriter, isMultiWriter := common.Cast[N.MultiBufferWriter](dst); isMultiWriter {
+		if multiReader, isMultiReader := common.Cast[N.MultiBufferReader](src); isMultiReader {
+			return CopyExtendedWithMultiBuffer(multiWriter, multiReader)
+		}
+	}
 	unsafeSrc, srcUnsafe := common.Cast[N.ThreadSafeReader](src)
 	headroom := N.CalculateFrontHeadroom(dst) + N.CalculateRearHeadroom(dst)
 	if srcUnsafe {
@@ -108,6 +113,37 @@ func CopyExtendedBuffer(dst N.ExtendedWriter, src N.ExtendedReader, buffer *buf.
 	}
 }
 
+// multiBufferBatchSize is the capacity CopyExtendedWithMultiBuffer and
+// CopyPacketWithMultiBuffer preallocate for each batch. It is not a limit: a
+// reader that appends more buffers than this grows the slice.
+const multiBufferBatchSize = 32
+
+func CopyExtendedWithMultiBuffer(dst N.MultiBufferWriter, src N.MultiBufferReader) (n int64, err error) {
+	buffers := make(buf.MultiBuffer, 0, multiBufferBatchSize)
+	var notFirstTime bool
+	for {
+		buffers, err = src.ReadBuffers(buffers[:0])
+		if err != nil {
+			buffers.Release()
+			if !notFirstTime {
+				err = N.HandshakeFailure(dst, err)
+			}
+			return
+		}
+		for _, buffer := range buffers {
+			n += int64(buffer.Len())
+		}
+		err = dst.WriteMultiBuffer(buffers)
+		if err != nil {
+			// dst takes ownership of buffers on every call regardless of
+			// outcome, same as WriteBuffer above, so it has already
+			// released them itself.
+			return
+		}
+		notFirstTime = true
+	}
+}
+
 func CopyExtendedWithSrcBuffer(dst N.ExtendedWriter, src N.ThreadSafeReader) (n int64, err error) {
 	var notFirstTime bool
 	for {
@@ -189,6 +225,11 @@ func CopyConn(ctx context.Context, conn net.Conn, dest net.Conn) error {
 }
 
 func CopyPacket(dst N.PacketWriter, src N.PacketReader) (n int64, err error) {
+	if multiWriter, isMultiWriter := common.Cast[N.MultiPacketWriter](dst); isMultiWriter {
+		if multiReader, isMultiReader := common.Cast[N.MultiPacketReader](src); isMultiReader {
+			return CopyPacketWithMultiBuffer(multiWriter, multiReader)
+		}
+	}
 	unsafeSrc, srcUnsafe := common.Cast[N.ThreadSafePacketReader](src)
 	frontHeadroom := N.CalculateFrontHeadroom(dst)
 	rearHeadroom := N.CalculateRearHeadroom(dst)
@@ -237,6 +278,35 @@ func CopyPacket(dst N.PacketWriter, src N.PacketReader) (n int64, err error) {
 	}
 }
 
+func CopyPacketWithMultiBuffer(dst N.MultiPacketWriter, src N.MultiPacketReader) (n int64, err error) {
+	buffers := make(buf.MultiBuffer, 0, multiBufferBatchSize)
+	var (
+		destinations []M.Socksaddr
+		notFirstTime bool
+	)
+	for {
+		buffers, destinations, err = src.ReadPackets(buffers[:0])
+		if err != nil {
+			buffers.Release()
+			if !notFirstTime {
+				err = N.HandshakeFailure(dst, err)
+			}
+			return
+		}
+		for _, buffer := range buffers {
+			n += int64(buffer.Len())
+		}
+		err = dst.WritePackets(buffers, destinations)
+		if err != nil {
+			// dst takes ownership of buffers on every call regardless of
+			// outcome, same as WritePacket in CopyPacket above, so it has
+			// already released them itself.
+			return
+		}
+		notFirstTime = true
+	}
+}
+
 func CopyPacketWithSrcBuffer(dst N.PacketWriter, src N.ThreadSafePacketReader) (n int64, err error) {
 	var buffer *buf.Buffer
 	var destination M.Socksaddr
@@ -0,0 +1,62 @@
+package bufio
+
+import (
+	"net"
+
+	"github.com/sagernet/sing/common/buf"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+// NewVectorisedPacketWriter wraps a net.PacketConn so it satisfies
+// N.MultiPacketWriter. On platforms that implement sendBatch (currently
+// Linux, via sendmmsg), a batch of datagrams addressed to different peers is
+// sent with a single syscall; everywhere else it falls back to one WriteTo
+// call per datagram.
+func NewVectorisedPacketWriter(conn net.PacketConn) N.MultiPacketWriter {
+	writer := &vectorisedPacketWriter{conn: conn, udpConn: asUDPConn(conn)}
+	if writer.udpConn != nil {
+		_ = N.EnablePathMTUDiscovery(writer.udpConn)
+	}
+	return writer
+}
+
+type vectorisedPacketWriter struct {
+	conn    net.PacketConn
+	udpConn *net.UDPConn
+}
+
+// PathMTU implements N.MTUProber, letting CalculateMTU pick up the kernel's
+// discovered path MTU for this socket instead of a conservative constant.
+func (w *vectorisedPacketWriter) PathMTU() (int, bool) {
+	if w.udpConn == nil {
+		return 0, false
+	}
+	return N.QueryPathMTU(w.udpConn)
+}
+
+func asUDPConn(conn net.PacketConn) *net.UDPConn {
+	udpConn, _ := conn.(*net.UDPConn)
+	return udpConn
+}
+
+func (w *vectorisedPacketWriter) WritePackets(buffers buf.MultiBuffer, destinations []M.Socksaddr) error {
+	defer buffers.Release()
+	pending, pendingDestinations := buffers, destinations
+	if w.udpConn != nil {
+		sent, ok := sendBatch(w.udpConn, buffers, destinations)
+		if ok {
+			return nil
+		}
+		// A partial sendmmsg batch must not be resent: only the unsent tail
+		// is retried, so already-delivered datagrams aren't duplicated.
+		pending, pendingDestinations = buffers[sent:], destinations[sent:]
+	}
+	for index, buffer := range pending {
+		_, err := w.conn.WriteTo(buffer.Bytes(), pendingDestinations[index].UDPAddr())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
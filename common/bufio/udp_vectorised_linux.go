@@ -0,0 +1,93 @@
+//go:build linux
+
+package bufio
+
+import (
+	"net"
+	"unsafe"
+
+	"github.com/sagernet/sing/common/buf"
+	M "github.com/sagernet/sing/common/metadata"
+	"golang.org/x/sys/unix"
+)
+
+// sendBatch sends every buffer in one or more sendmmsg(2) calls, each
+// datagram addressed to its own destination. It returns how many leading
+// buffers were actually sent and whether the whole batch was delivered; the
+// caller must resend only buffers[sent:] itself, since a mid-batch error
+// (e.g. transient ENOBUFS/EAGAIN) can leave a batch partially sent. sent is
+// always 0 when ok is false for a reason other than a partial send (the
+// socket's file descriptor couldn't be obtained, or a destination isn't a
+// plain IPv4/IPv6 address sendmmsg can target directly).
+func sendBatch(conn *net.UDPConn, buffers buf.MultiBuffer, destinations []M.Socksaddr) (sent int, ok bool) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+
+	messages := make([]unix.Mmsghdr, len(buffers))
+	iovecs := make([]unix.Iovec, len(buffers))
+	names := make([]unix.RawSockaddrAny, len(buffers))
+	for index, buffer := range buffers {
+		if buffer.Len() > 0 {
+			iovecs[index].Base = &buffer.Bytes()[0]
+			iovecs[index].SetLen(buffer.Len())
+		}
+		messages[index].Hdr.Iov = &iovecs[index]
+		messages[index].Hdr.Iovlen = 1
+
+		nameLen, valid := putSockaddr(&names[index], destinations[index])
+		if !valid {
+			return 0, false
+		}
+		messages[index].Hdr.Name = (*byte)(unsafe.Pointer(&names[index]))
+		messages[index].Hdr.Namelen = nameLen
+	}
+
+	var sendErr error
+	controlErr := rawConn.Write(func(fd uintptr) bool {
+		for sent < len(messages) {
+			n, errno := unix.Sendmmsg(int(fd), messages[sent:], 0)
+			if errno != nil {
+				sendErr = errno
+				return true
+			}
+			if n == 0 {
+				return true
+			}
+			sent += n
+		}
+		return true
+	})
+	return sent, controlErr == nil && sendErr == nil && sent == len(messages)
+}
+
+// putSockaddr fills raw with the sockaddr_in/sockaddr_in6 representation of
+// destination and returns its length, or false if destination isn't a
+// plain IP address sendmmsg can use directly.
+func putSockaddr(raw *unix.RawSockaddrAny, destination M.Socksaddr) (uint32, bool) {
+	addr := destination.UDPAddr()
+	if addr == nil {
+		return 0, false
+	}
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(raw))
+		sa.Family = unix.AF_INET
+		sa.Port = htons(uint16(addr.Port))
+		copy(sa.Addr[:], ip4)
+		return unix.SizeofSockaddrInet4, true
+	}
+	ip6 := addr.IP.To16()
+	if ip6 == nil {
+		return 0, false
+	}
+	sa := (*unix.RawSockaddrInet6)(unsafe.Pointer(raw))
+	sa.Family = unix.AF_INET6
+	sa.Port = htons(uint16(addr.Port))
+	copy(sa.Addr[:], ip6)
+	return unix.SizeofSockaddrInet6, true
+}
+
+func htons(port uint16) uint16 {
+	return (port << 8) | (port >> 8)
+}
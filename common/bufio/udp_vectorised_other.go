@@ -0,0 +1,17 @@
+//go:build !linux
+
+package bufio
+
+import (
+	"net"
+
+	"github.com/sagernet/sing/common/buf"
+	M "github.com/sagernet/sing/common/metadata"
+)
+
+// sendBatch reports whether it handled the batch itself; on platforms
+// without a vectorized send path it always returns 0, false so the caller
+// falls back to one WriteTo per datagram.
+func sendBatch(conn *net.UDPConn, buffers buf.MultiBuffer, destinations []M.Socksaddr) (sent int, ok bool) {
+	return 0, false
+}
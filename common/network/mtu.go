@@ -0,0 +1,31 @@
+package network
+
+// MTUProber is implemented by packet connections that can report their
+// current best estimate of the path MTU to their peer, typically obtained
+// through kernel path MTU discovery (IP(V6)_MTU_DISCOVER + IP(V6)_MTU).
+// CalculateMTU consults it when present, so CopyPacket can size its buffer
+// to the real path instead of falling back to a conservative constant.
+type MTUProber interface {
+	// PathMTU returns the discovered path MTU in bytes, and whether a
+	// usable measurement is available yet.
+	PathMTU() (mtu int, ok bool)
+}
+
+// CalculateMTU returns the buffer size CopyPacket/CopyExtended should use
+// for proxying between src and dst, preferring a real measurement reported
+// by either side through MTUProber. It returns 0 if neither src nor dst has
+// a usable measurement, letting the caller fall back to its own
+// conservative default.
+func CalculateMTU(src, dst any) int {
+	if prober, ok := dst.(MTUProber); ok {
+		if mtu, ok := prober.PathMTU(); ok {
+			return mtu
+		}
+	}
+	if prober, ok := src.(MTUProber); ok {
+		if mtu, ok := prober.PathMTU(); ok {
+			return mtu
+		}
+	}
+	return 0
+}
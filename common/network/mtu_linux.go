@@ -0,0 +1,63 @@
+//go:build linux
+
+package network
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// EnablePathMTUDiscovery turns on kernel path MTU discovery for conn, so a
+// later QueryPathMTU call can report a real measurement once the kernel has
+// observed a fragmentation-needed response for this socket.
+func EnablePathMTUDiscovery(conn *net.UDPConn) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var setErr error
+	controlErr := rawConn.Control(func(fd uintptr) {
+		if isIPv6(conn) {
+			setErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_DO)
+			return
+		}
+		setErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_DO)
+	})
+	if controlErr != nil {
+		return controlErr
+	}
+	return setErr
+}
+
+// QueryPathMTU reads back the kernel's current path MTU estimate for conn.
+// ok is false until the kernel has actually produced one, e.g. because no
+// fragmentation-needed ICMP has arrived yet.
+func QueryPathMTU(conn *net.UDPConn) (mtu int, ok bool) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	controlErr := rawConn.Control(func(fd uintptr) {
+		if isIPv6(conn) {
+			value, getErr := unix.GetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU)
+			if getErr == nil && value > 0 {
+				mtu, ok = value, true
+			}
+			return
+		}
+		value, getErr := unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU)
+		if getErr == nil && value > 0 {
+			mtu, ok = value, true
+		}
+	})
+	if controlErr != nil {
+		return 0, false
+	}
+	return
+}
+
+func isIPv6(conn *net.UDPConn) bool {
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	return ok && addr.IP.To4() == nil
+}
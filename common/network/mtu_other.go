@@ -0,0 +1,15 @@
+//go:build !linux
+
+package network
+
+import "net"
+
+// EnablePathMTUDiscovery is a no-op on platforms without the
+// IP(V6)_MTU_DISCOVER sockopt; QueryPathMTU always reports no measurement.
+func EnablePathMTUDiscovery(conn *net.UDPConn) error {
+	return nil
+}
+
+func QueryPathMTU(conn *net.UDPConn) (mtu int, ok bool) {
+	return 0, false
+}
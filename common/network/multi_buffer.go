@@ -0,0 +1,37 @@
+package network
+
+import (
+	"github.com/sagernet/sing/common/buf"
+	M "github.com/sagernet/sing/common/metadata"
+)
+
+// MultiBufferReader is implemented by readers that can fill several buffers
+// per call instead of forcing callers through a buffer-at-a-time ReadBuffer
+// loop. buffers is reused across calls by the caller and is expected to be
+// returned extended with the buffers read, analogous to append.
+type MultiBufferReader interface {
+	ReadBuffers(buffers buf.MultiBuffer) (buf.MultiBuffer, error)
+}
+
+// MultiBufferWriter is implemented by stream writers that can accept a
+// batch of buffers per call.
+type MultiBufferWriter interface {
+	WriteMultiBuffer(buffers buf.MultiBuffer) error
+}
+
+// MultiPacketWriter is implemented by packet writers that can accept a
+// batch of datagrams, each addressed to its own destination, per call. On
+// platforms where the underlying transport supports it (e.g. Linux via
+// sendmmsg), this lets high-packet-rate relays avoid one syscall per
+// datagram.
+type MultiPacketWriter interface {
+	WritePackets(buffers buf.MultiBuffer, destinations []M.Socksaddr) error
+}
+
+// MultiPacketReader is implemented by packet readers that can fill several
+// datagrams per call, each paired with the source/destination it arrived
+// with. buffers is reused across calls in the same way as
+// MultiBufferReader.ReadBuffers.
+type MultiPacketReader interface {
+	ReadPackets(buffers buf.MultiBuffer) (buf.MultiBuffer, []M.Socksaddr, error)
+}
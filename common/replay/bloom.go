@@ -0,0 +1,172 @@
+package replay
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing/common"
+)
+
+// bloomHashCount is the number of hash probes per insert/lookup, chosen
+// with the usual k = (m/n) * ln(2) rule of thumb for about 10 bits per
+// expected entry.
+const bloomHashCount = 7
+
+// bloomKeySize is the length of the random per-instance HMAC key saltHashes
+// uses, so an attacker can't engineer a colliding salt without knowing it.
+const bloomKeySize = 32
+
+type bloomFilter struct {
+	bits []uint64
+	set  int
+}
+
+func newBloomFilter(bits int) *bloomFilter {
+	return &bloomFilter{bits: make([]uint64, (bits+63)/64)}
+}
+
+func (f *bloomFilter) size() int {
+	return len(f.bits) * 64
+}
+
+func (f *bloomFilter) index(h1, h2 uint64, i int) (word int, bit uint64) {
+	slot := (h1 + uint64(i)*h2) % uint64(f.size())
+	return int(slot / 64), slot % 64
+}
+
+func (f *bloomFilter) add(h1, h2 uint64) {
+	for i := 0; i < bloomHashCount; i++ {
+		word, bit := f.index(h1, h2, i)
+		mask := uint64(1) << bit
+		if f.bits[word]&mask == 0 {
+			f.bits[word] |= mask
+			f.set++
+		}
+	}
+}
+
+func (f *bloomFilter) test(h1, h2 uint64) bool {
+	for i := 0; i < bloomHashCount; i++ {
+		word, bit := f.index(h1, h2, i)
+		if f.bits[word]&(uint64(1)<<bit) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type bloomShard struct {
+	access    sync.Mutex
+	oldFilter *bloomFilter
+	newFilter *bloomFilter
+	rotatedAt time.Time
+	interval  time.Duration
+	bits      int
+}
+
+func (s *bloomShard) rotateLocked(now time.Time) {
+	if now.Sub(s.rotatedAt) < s.interval {
+		return
+	}
+	s.oldFilter = s.newFilter
+	s.newFilter = newBloomFilter(s.bits)
+	s.rotatedAt = now
+}
+
+// Bloom is a sharded, time-windowed bloom filter implementation of
+// replay.Filter. Unlike the default cuckoo filter, inserts and lookups for
+// different salts usually land on independently-locked shards, which keeps
+// lock contention low enough to sustain the very high salt rates a
+// multi-user shadowsocks-2022 listener can see. Salts are reduced to their
+// probe positions via a random, per-instance HMAC key rather than a plain
+// hash, so an attacker can't engineer a colliding salt to force a false
+// "already replayed" rejection against a target client without already
+// knowing the key.
+type Bloom struct {
+	key    [bloomKeySize]byte
+	shards []*bloomShard
+}
+
+// NewBloom creates a Bloom filter with shardCount independently-locked
+// shards, each holding two generations (current and previous window) of a
+// bitsPerShard-sized bitmap that rotate every interval - mirroring the
+// two-generation rotation NewCuckoo uses, but sharded for concurrency.
+func NewBloom(interval time.Duration, shardCount int, bitsPerShard int) *Bloom {
+	b := &Bloom{shards: make([]*bloomShard, shardCount)}
+	common.Must1(io.ReadFull(rand.Reader, b.key[:]))
+	now := time.Now()
+	for i := range b.shards {
+		b.shards[i] = &bloomShard{
+			newFilter: newBloomFilter(bitsPerShard),
+			rotatedAt: now,
+			interval:  interval,
+			bits:      bitsPerShard,
+		}
+	}
+	return b
+}
+
+func (b *Bloom) shardFor(h1 uint64) *bloomShard {
+	return b.shards[h1%uint64(len(b.shards))]
+}
+
+// saltHashes derives the two probe hashes for salt via HMAC-SHA256 keyed
+// with b.key, so they can't be engineered by an attacker without the key.
+func (b *Bloom) saltHashes(salt []byte) (h1, h2 uint64) {
+	mac1 := hmac.New(sha256.New, b.key[:])
+	mac1.Write(salt)
+	h1 = binary.BigEndian.Uint64(mac1.Sum(nil)[:8])
+
+	mac2 := hmac.New(sha256.New, b.key[:])
+	mac2.Write(salt)
+	mac2.Write([]byte{0xff})
+	h2 = binary.BigEndian.Uint64(mac2.Sum(nil)[:8])
+	if h2 == 0 {
+		h2 = 1
+	}
+	return
+}
+
+func (b *Bloom) Check(salt []byte) bool {
+	h1, h2 := b.saltHashes(salt)
+	shard := b.shardFor(h1)
+
+	shard.access.Lock()
+	defer shard.access.Unlock()
+
+	shard.rotateLocked(time.Now())
+	if shard.newFilter.test(h1, h2) || (shard.oldFilter != nil && shard.oldFilter.test(h1, h2)) {
+		return false
+	}
+	shard.newFilter.add(h1, h2)
+	return true
+}
+
+// FillRate returns the fraction of bits set across every shard's active
+// generation.
+func (b *Bloom) FillRate() float64 {
+	var used, total int
+	for _, shard := range b.shards {
+		shard.access.Lock()
+		used += shard.newFilter.set
+		total += shard.newFilter.size()
+		shard.access.Unlock()
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(used) / float64(total)
+}
+
+// FalsePositiveRate estimates the current false-positive probability from
+// the fill rate using the standard bloom filter approximation
+// (fillRate)^k.
+func (b *Bloom) FalsePositiveRate() float64 {
+	return math.Pow(b.FillRate(), float64(bloomHashCount))
+}
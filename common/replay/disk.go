@@ -0,0 +1,192 @@
+package replay
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// diskKeySize is the length of the per-file HMAC key stored in the file
+// header, generated on first use and reused across restarts so persisted
+// hashes stay comparable.
+const diskKeySize = 32
+
+// diskRecordSize is the on-disk record layout: 8 bytes salt hash followed
+// by 8 bytes of the Unix nanosecond timestamp it was seen at.
+const diskRecordSize = 16
+
+// Disk is a replay filter backed by a fixed-size ring file, so that a
+// restart reloads the salts seen within the replay window instead of
+// silently reopening it. Salts are reduced to a 64-bit HMAC-SHA256 keyed
+// with a random, per-file key (stored in the file header) rather than a
+// plain hash like FNV, so an attacker can't engineer a collision by
+// choosing salt bytes without already knowing the key. The 64-bit
+// truncation still leaves a small birthday collision probability, reported
+// by FalsePositiveRate - Disk is not exact the way its fixed-size index
+// might suggest, just far more collision-resistant than a keyless sketch.
+type Disk struct {
+	access     sync.Mutex
+	file       *os.File
+	key        [diskKeySize]byte
+	capacity   int64
+	interval   time.Duration
+	index      map[uint64]time.Time
+	cursor     int64
+	checkCount uint64
+}
+
+// NewDisk opens (creating if necessary) a ring file at path able to hold up
+// to capacity entries, each considered replayed for interval after being
+// seen.
+func NewDisk(path string, interval time.Duration, capacity int64) (*Disk, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	d := &Disk{
+		file:     file,
+		capacity: capacity,
+		interval: interval,
+		index:    make(map[uint64]time.Time),
+	}
+	if err = d.loadKey(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err = d.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// loadKey reads the HMAC key from the file header, generating and
+// persisting a new random one if the file is new or its header is short.
+func (d *Disk) loadKey() error {
+	var key [diskKeySize]byte
+	if n, err := d.file.ReadAt(key[:], 0); err == nil && n == diskKeySize {
+		d.key = key
+		return nil
+	}
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return err
+	}
+	if _, err := d.file.WriteAt(key[:], 0); err != nil {
+		return err
+	}
+	d.key = key
+	return nil
+}
+
+// recordOffset returns the file offset of ring slot i, past the key header.
+func (d *Disk) recordOffset(i int64) int64 {
+	return diskKeySize + i*diskRecordSize
+}
+
+func (d *Disk) load() error {
+	buffer := make([]byte, diskRecordSize)
+	now := time.Now()
+	for i := int64(0); i < d.capacity; i++ {
+		_, err := d.file.ReadAt(buffer, d.recordOffset(i))
+		if err != nil {
+			break
+		}
+		hash := binary.BigEndian.Uint64(buffer[:8])
+		if hash == 0 {
+			continue
+		}
+		seenAt := time.Unix(0, int64(binary.BigEndian.Uint64(buffer[8:])))
+		if now.Sub(seenAt) < d.interval {
+			d.index[hash] = seenAt
+		}
+		d.cursor = (i + 1) % d.capacity
+	}
+	return nil
+}
+
+func (d *Disk) Check(salt []byte) bool {
+	hash := d.hashSalt(salt)
+	now := time.Now()
+
+	d.access.Lock()
+	defer d.access.Unlock()
+
+	if seenAt, loaded := d.index[hash]; loaded && now.Sub(seenAt) < d.interval {
+		return false
+	}
+
+	d.index[hash] = now
+	d.persistLocked(hash, now)
+
+	d.checkCount++
+	if d.checkCount%1024 == 0 {
+		d.evictLocked(now)
+	}
+	return true
+}
+
+func (d *Disk) persistLocked(hash uint64, at time.Time) {
+	var record [diskRecordSize]byte
+	binary.BigEndian.PutUint64(record[:8], hash)
+	binary.BigEndian.PutUint64(record[8:], uint64(at.UnixNano()))
+	_, _ = d.file.WriteAt(record[:], d.recordOffset(d.cursor))
+	d.cursor = (d.cursor + 1) % d.capacity
+}
+
+// hashSalt reduces salt to a 64-bit value via HMAC-SHA256 keyed with d.key,
+// so an attacker without the key can't pick salt bytes to force a
+// collision the way they could against an unkeyed hash like FNV.
+func (d *Disk) hashSalt(salt []byte) uint64 {
+	mac := hmac.New(sha256.New, d.key[:])
+	mac.Write(salt)
+	return binary.BigEndian.Uint64(mac.Sum(nil)[:8])
+}
+
+func (d *Disk) evictLocked(now time.Time) {
+	for hash, seenAt := range d.index {
+		if now.Sub(seenAt) >= d.interval {
+			delete(d.index, hash)
+		}
+	}
+}
+
+// FillRate returns the fraction of the ring's capacity currently holding a
+// live (not yet expired) entry.
+func (d *Disk) FillRate() float64 {
+	d.access.Lock()
+	defer d.access.Unlock()
+	if d.capacity == 0 {
+		return 0
+	}
+	d.evictLocked(time.Now())
+	live := int64(len(d.index))
+	if live > d.capacity {
+		live = d.capacity
+	}
+	return float64(live) / float64(d.capacity)
+}
+
+// FalsePositiveRate estimates the current false-positive probability from
+// the birthday bound over the 64-bit hash space: with n live entries, the
+// chance any two distinct salts collide is roughly n^2 / (2 * 2^64). It is
+// not zero - truncating the keyed hash to 64 bits leaves a (very small)
+// collision probability even though the key prevents an attacker from
+// choosing a colliding salt deliberately.
+func (d *Disk) FalsePositiveRate() float64 {
+	d.access.Lock()
+	d.evictLocked(time.Now())
+	n := float64(len(d.index))
+	d.access.Unlock()
+	return (n * n) / (2 * math.Pow(2, 64))
+}
+
+// Close releases the underlying ring file.
+func (d *Disk) Close() error {
+	return d.file.Close()
+}
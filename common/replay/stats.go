@@ -0,0 +1,16 @@
+package replay
+
+// StatsFilter is implemented by Filter backends that can report their
+// current saturation, letting operators alert before a filter's capacity is
+// exhausted and it starts rejecting requests that were never actually
+// replayed.
+type StatsFilter interface {
+	Filter
+
+	// FillRate returns the estimated fraction of filter capacity currently
+	// in use, in [0, 1].
+	FillRate() float64
+	// FalsePositiveRate returns the filter's estimated current
+	// false-positive probability.
+	FalsePositiveRate() float64
+}
@@ -0,0 +1,63 @@
+// Package trafficcontrol provides small, dependency-free interfaces for
+// metering and rate limiting traffic at the protocol layer, so deployments
+// can enforce quotas and export abuse signals without patching the crypto
+// path of each protocol implementation.
+package trafficcontrol
+
+import "sync/atomic"
+
+// TrafficMeter records byte/packet counters for a connection, UDP session,
+// or user, plus protocol-level failure counts useful for abuse detection.
+// Implementations must be safe for concurrent use.
+type TrafficMeter interface {
+	AddUpload(n int64)
+	AddDownload(n int64)
+	AddUploadPackets(n int64)
+	AddDownloadPackets(n int64)
+	AddDecryptFailure()
+	AddReplayHit()
+}
+
+// MeterSnapshot is a point-in-time copy of a Meter's counters, shaped so it
+// can be mapped directly onto Prometheus gauges/counters by the caller.
+type MeterSnapshot struct {
+	Upload          int64
+	Download        int64
+	UploadPackets   int64
+	DownloadPackets int64
+	DecryptFailures int64
+	ReplayHits      int64
+}
+
+// Meter is the default in-memory TrafficMeter.
+type Meter struct {
+	upload          int64
+	download        int64
+	uploadPackets   int64
+	downloadPackets int64
+	decryptFailures int64
+	replayHits      int64
+}
+
+func NewMeter() *Meter {
+	return &Meter{}
+}
+
+func (m *Meter) AddUpload(n int64)          { atomic.AddInt64(&m.upload, n) }
+func (m *Meter) AddDownload(n int64)        { atomic.AddInt64(&m.download, n) }
+func (m *Meter) AddUploadPackets(n int64)   { atomic.AddInt64(&m.uploadPackets, n) }
+func (m *Meter) AddDownloadPackets(n int64) { atomic.AddInt64(&m.downloadPackets, n) }
+func (m *Meter) AddDecryptFailure()         { atomic.AddInt64(&m.decryptFailures, 1) }
+func (m *Meter) AddReplayHit()              { atomic.AddInt64(&m.replayHits, 1) }
+
+// Snapshot returns a consistent-enough point-in-time copy of every counter.
+func (m *Meter) Snapshot() MeterSnapshot {
+	return MeterSnapshot{
+		Upload:          atomic.LoadInt64(&m.upload),
+		Download:        atomic.LoadInt64(&m.download),
+		UploadPackets:   atomic.LoadInt64(&m.uploadPackets),
+		DownloadPackets: atomic.LoadInt64(&m.downloadPackets),
+		DecryptFailures: atomic.LoadInt64(&m.decryptFailures),
+		ReplayHits:      atomic.LoadInt64(&m.replayHits),
+	}
+}
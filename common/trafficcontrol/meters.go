@@ -0,0 +1,79 @@
+package trafficcontrol
+
+import "sync"
+
+// Meters is a registry of per-user Meter instances, handy as the backing
+// store for code that needs a cumulative, per-user view in addition to
+// whatever per-connection meter it already keeps.
+type Meters struct {
+	access sync.Mutex
+	byUser map[string]*Meter
+}
+
+func NewMeters() *Meters {
+	return &Meters{byUser: make(map[string]*Meter)}
+}
+
+// User returns the Meter for name, creating it on first use.
+func (m *Meters) User(name string) *Meter {
+	m.access.Lock()
+	defer m.access.Unlock()
+	meter, loaded := m.byUser[name]
+	if !loaded {
+		meter = NewMeter()
+		m.byUser[name] = meter
+	}
+	return meter
+}
+
+// Snapshot returns a point-in-time copy of every registered user's counters.
+func (m *Meters) Snapshot() map[string]MeterSnapshot {
+	m.access.Lock()
+	defer m.access.Unlock()
+	snapshot := make(map[string]MeterSnapshot, len(m.byUser))
+	for name, meter := range m.byUser {
+		snapshot[name] = meter.Snapshot()
+	}
+	return snapshot
+}
+
+// MultiMeter fans every call out to each underlying TrafficMeter, so a
+// caller can update a per-connection meter and a per-user Meters entry from
+// a single call site.
+type MultiMeter []TrafficMeter
+
+func (m MultiMeter) AddUpload(n int64) {
+	for _, meter := range m {
+		meter.AddUpload(n)
+	}
+}
+
+func (m MultiMeter) AddDownload(n int64) {
+	for _, meter := range m {
+		meter.AddDownload(n)
+	}
+}
+
+func (m MultiMeter) AddUploadPackets(n int64) {
+	for _, meter := range m {
+		meter.AddUploadPackets(n)
+	}
+}
+
+func (m MultiMeter) AddDownloadPackets(n int64) {
+	for _, meter := range m {
+		meter.AddDownloadPackets(n)
+	}
+}
+
+func (m MultiMeter) AddDecryptFailure() {
+	for _, meter := range m {
+		meter.AddDecryptFailure()
+	}
+}
+
+func (m MultiMeter) AddReplayHit() {
+	for _, meter := range m {
+		meter.AddReplayHit()
+	}
+}
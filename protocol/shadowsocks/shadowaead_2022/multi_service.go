@@ -0,0 +1,186 @@
+package shadowaead_2022
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/sagernet/sing/common/buf"
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+	"github.com/sagernet/sing/protocol/shadowsocks"
+	"github.com/sagernet/sing/protocol/shadowsocks/shadowaead"
+	"github.com/sagernet/sing/protocol/socks"
+	"lukechampine.com/blake3"
+)
+
+// IdentityHeaderSize is the size in bytes of the Extensible Identity Header
+// (EIH) that precedes the regular request/session header when a service is
+// multiplexing users.
+const IdentityHeaderSize = aes.BlockSize
+
+// multiUser holds the material derived once per registered user so that
+// hot path packet/connection handling never has to recompute it.
+type multiUser struct {
+	name           string
+	psk            []byte
+	udpBlockCipher cipher.Block
+	udpCipher      cipher.AEAD
+}
+
+// MultiService extends Service with support for the Shadowsocks 2022
+// Extensible Identity Header scheme, allowing any number of users to share a
+// single listener and PSK-derived identity while each decrypts traffic with
+// their own PSK.
+type MultiService struct {
+	*Service
+	iPSK   []byte
+	eihKey []byte
+
+	access     sync.RWMutex
+	users      map[string]*multiUser
+	userHashes map[[IdentityHeaderSize]byte]*multiUser
+}
+
+// NewMultiService creates a shadowsocks 2022 service that multiplexes users
+// on a single listener. iPSK protects the identity header and is shared by
+// every user; uPSKs maps a user name to that user's individual PSK.
+func NewMultiService(method string, iPSK []byte, uPSKs map[string][]byte, secureRNG io.Reader, udpTimeout int64, handler shadowsocks.Handler) (shadowsocks.Service, error) {
+	service, err := NewService(method, iPSK, secureRNG, udpTimeout, handler)
+	if err != nil {
+		return nil, err
+	}
+	s := &MultiService{
+		Service:    service.(*Service),
+		iPSK:       iPSK,
+		eihKey:     deriveEIHKey(iPSK, nil, service.(*Service).keyLength),
+		users:      make(map[string]*multiUser),
+		userHashes: make(map[[IdentityHeaderSize]byte]*multiUser),
+	}
+	for name, psk := range uPSKs {
+		err = s.AddUser(name, psk)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// AddUser registers or replaces a user's PSK, making it immediately eligible
+// to authenticate new connections and UDP sessions.
+func (s *MultiService) AddUser(name string, psk []byte) error {
+	if len(psk) != KeySaltSize {
+		return shadowaead.ErrBadKey
+	}
+
+	user := &multiUser{
+		name: name,
+		psk:  psk,
+	}
+	if s.blockConstructor != nil {
+		user.udpBlockCipher = s.blockConstructor(psk)
+	} else {
+		user.udpCipher = s.udpConstructor(psk)
+	}
+
+	s.access.Lock()
+	defer s.access.Unlock()
+	if old, loaded := s.users[name]; loaded {
+		delete(s.userHashes, pskHash(old.psk))
+	}
+	s.users[name] = user
+	s.userHashes[pskHash(psk)] = user
+	return nil
+}
+
+// RemoveUser revokes a user's PSK. In-flight connections already handed to
+// the handler are unaffected.
+func (s *MultiService) RemoveUser(name string) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	user, loaded := s.users[name]
+	if !loaded {
+		return
+	}
+	delete(s.users, name)
+	delete(s.userHashes, pskHash(user.psk))
+}
+
+func (s *MultiService) lookup(identityHeader []byte) (*multiUser, bool) {
+	var hash [IdentityHeaderSize]byte
+	copy(hash[:], identityHeader)
+	s.access.RLock()
+	defer s.access.RUnlock()
+	user, loaded := s.userHashes[hash]
+	return user, loaded
+}
+
+func (s *MultiService) NewConnection(ctx context.Context, conn net.Conn, metadata M.Metadata) error {
+	requestSalt, err := s.readRequestSalt(conn)
+	if err != nil {
+		return err
+	}
+
+	identityHeader := make([]byte, IdentityHeaderSize)
+	_, err = io.ReadFull(conn, identityHeader)
+	if err != nil {
+		return E.Cause(err, "read identity header")
+	}
+
+	eihBlock, err := aes.NewCipher(deriveEIHKey(s.iPSK, requestSalt, s.keyLength))
+	if err != nil {
+		return E.Cause(err, "create identity cipher")
+	}
+	eihBlock.Decrypt(identityHeader, identityHeader)
+
+	user, loaded := s.lookup(identityHeader)
+	if !loaded {
+		return E.New("unknown identity")
+	}
+
+	metadata.Protocol = "shadowsocks"
+	return s.newConnection(ctx, conn, metadata, requestSalt, user.psk, user.name)
+}
+
+func (s *MultiService) NewPacket(conn socks.PacketConn, buffer *buf.Buffer, metadata M.Metadata) error {
+	identityHeader := buffer.To(IdentityHeaderSize)
+
+	eihBlock, err := aes.NewCipher(s.eihKey)
+	if err != nil {
+		return E.Cause(err, "create identity cipher")
+	}
+	eihBlock.Decrypt(identityHeader, identityHeader)
+
+	user, loaded := s.lookup(identityHeader)
+	if !loaded {
+		return E.New("unknown identity")
+	}
+	buffer.Advance(IdentityHeaderSize)
+
+	return s.Service.newPacket(conn, buffer, metadata, user.psk, user.udpBlockCipher, user.udpCipher, user.name)
+}
+
+// deriveEIHKey derives the AES key used to encrypt/decrypt an identity
+// header. For TCP, salt is the per-connection request salt; for UDP, where
+// there is no salt, it is left empty and the key is constant for the
+// lifetime of the service.
+func deriveEIHKey(iPSK []byte, salt []byte, keyLength int) []byte {
+	material := make([]byte, 0, len(iPSK)+len(salt))
+	material = append(material, iPSK...)
+	material = append(material, salt...)
+	sum := blake3.Sum512(material)
+	return sum[:keyLength]
+}
+
+// pskHash is the lookup key for a user's PSK: the first IdentityHeaderSize
+// bytes of its blake3 hash, matching what the client encrypts into the
+// identity header.
+func pskHash(psk []byte) [IdentityHeaderSize]byte {
+	sum := blake3.Sum512(psk)
+	var hash [IdentityHeaderSize]byte
+	copy(hash[:], sum[:IdentityHeaderSize])
+	return hash
+}
@@ -0,0 +1,80 @@
+package shadowaead_2022
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// PaddingPolicy decides how many bytes of random padding a server->client
+// UDP response should carry. Without it, the exact length of a response
+// leaks through the crypto layer; the Shadowsocks 2022 spec recommends
+// padding DNS-like and otherwise-small responses to blunt that signal.
+type PaddingPolicy interface {
+	// PaddingLength returns the number of padding bytes to insert for a
+	// response of payloadLength bytes addressed to destinationPort.
+	PaddingLength(destinationPort uint16, payloadLength int) int
+}
+
+// NoPadding never pads, matching the server's historical behavior.
+func NoPadding() PaddingPolicy {
+	return noPadding{}
+}
+
+type noPadding struct{}
+
+func (noPadding) PaddingLength(uint16, int) int { return 0 }
+
+// RandomUpToPadding pads every response with a uniformly random length in
+// [0, max], regardless of destination or size.
+func RandomUpToPadding(max int, secureRNG io.Reader) PaddingPolicy {
+	return &randomUpToPadding{max: max, secureRNG: secureRNG}
+}
+
+type randomUpToPadding struct {
+	max       int
+	secureRNG io.Reader
+}
+
+func (p *randomUpToPadding) PaddingLength(uint16, int) int {
+	return randomPaddingLength(p.secureRNG, p.max)
+}
+
+// dnsPayloadPort and smallPayloadThreshold bound when MatchClientPadding
+// treats a response as DNS-like: addressed to port 53, or short enough that
+// its exact length would otherwise stand out.
+const (
+	dnsPayloadPort        = 53
+	smallPayloadThreshold = 256
+)
+
+// MatchClientPadding pads DNS-like responses (destination port 53, or a
+// short payload) with a random length up to max, and leaves everything else
+// unpadded, following the Shadowsocks 2022 spec's padding guidance without
+// adding overhead to ordinary traffic.
+func MatchClientPadding(max int, secureRNG io.Reader) PaddingPolicy {
+	return &matchClientPadding{max: max, secureRNG: secureRNG}
+}
+
+type matchClientPadding struct {
+	max       int
+	secureRNG io.Reader
+}
+
+func (p *matchClientPadding) PaddingLength(destinationPort uint16, payloadLength int) int {
+	if destinationPort != dnsPayloadPort && payloadLength >= smallPayloadThreshold {
+		return 0
+	}
+	return randomPaddingLength(p.secureRNG, p.max)
+}
+
+func randomPaddingLength(secureRNG io.Reader, max int) int {
+	if max <= 0 {
+		return 0
+	}
+	var b [2]byte
+	_, err := io.ReadFull(secureRNG, b[:])
+	if err != nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b[:])) % (max + 1)
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/binary"
+	"errors"
 	"io"
 	"math"
 	"net"
@@ -18,8 +19,10 @@ import (
 	"github.com/sagernet/sing/common/cache"
 	E "github.com/sagernet/sing/common/exceptions"
 	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
 	"github.com/sagernet/sing/common/replay"
 	"github.com/sagernet/sing/common/rw"
+	"github.com/sagernet/sing/common/trafficcontrol"
 	"github.com/sagernet/sing/common/udpnat"
 	"github.com/sagernet/sing/protocol/shadowsocks"
 	"github.com/sagernet/sing/protocol/shadowsocks/shadowaead"
@@ -33,6 +36,7 @@ type Service struct {
 	keyLength        int
 	constructor      func(key []byte) cipher.AEAD
 	blockConstructor func(key []byte) cipher.Block
+	udpConstructor   func(key []byte) cipher.AEAD
 	udpCipher        cipher.AEAD
 	udpBlockCipher   cipher.Block
 	psk              []byte
@@ -40,16 +44,89 @@ type Service struct {
 	handler          shadowsocks.Handler
 	udpNat           udpnat.Service[uint64]
 	sessions         cache.LruCache[uint64, *serverUDPSession]
+	trafficMeter     func(user string) trafficcontrol.TrafficMeter
+	rateLimiter      trafficcontrol.RateLimiter
+	maxSessionPeers  int
+	sessionPeerTTL   time.Duration
+	paddingPolicy    PaddingPolicy
+}
+
+// ServiceOptions configures the pieces of Service that go beyond the
+// method/psk/handler every service needs. A zero-value ServiceOptions
+// reproduces NewService's historical defaults.
+type ServiceOptions struct {
+	SecureRNG  io.Reader
+	UDPTimeout int64
+	Handler    shadowsocks.Handler
+
+	// ReplayFilter constructs the replay.Filter the service checks request
+	// salts against. If nil, it defaults to replay.NewCuckoo(60), matching
+	// NewService's previous hardcoded behavior.
+	ReplayFilter func() replay.Filter
+
+	// TrafficMeter, if set, is called with the resolved user (empty string
+	// for single-user services) to obtain the TrafficMeter that connection
+	// and packet handling report byte/packet counts and decrypt
+	// failures/replay hits to. It is called again for each packet, failure
+	// or replay hit, so it runs many times for the same user.
+	TrafficMeter func(user string) trafficcontrol.TrafficMeter
+
+	// RateLimiter, if set, is consulted before a new connection/packet
+	// session is handed to Handler, and again before every server->client UDP
+	// packet write. Writes on TCP connections are not rate limited.
+	RateLimiter trafficcontrol.RateLimiter
+
+	// MaxSessionPeers bounds how many distinct remote AddrPorts a single UDP
+	// session accepts packets from; once reached, a not-yet-seen peer's
+	// packets are rejected until an existing one ages out. Zero defaults to
+	// defaultMaxSessionPeers.
+	MaxSessionPeers int
+
+	// SessionPeerTTL bounds how long a remote AddrPort is remembered by a
+	// UDP session without being seen again, freeing up room under
+	// MaxSessionPeers for a new peer once it expires. Zero defaults to
+	// defaultSessionPeerTTL.
+	SessionPeerTTL time.Duration
+
+	// PaddingPolicy decides how much random padding server->client UDP
+	// responses carry. If nil, it defaults to NoPadding, matching the
+	// service's historical behavior of never padding.
+	PaddingPolicy PaddingPolicy
 }
 
 func NewService(method string, psk []byte, secureRNG io.Reader, udpTimeout int64, handler shadowsocks.Handler) (shadowsocks.Service, error) {
+	return NewServiceWithOptions(method, psk, ServiceOptions{
+		SecureRNG:  secureRNG,
+		UDPTimeout: udpTimeout,
+		Handler:    handler,
+	})
+}
+
+// NewServiceWithOptions is NewService with a pluggable replay-protection
+// backend; see ServiceOptions.
+func NewServiceWithOptions(method string, psk []byte, options ServiceOptions) (shadowsocks.Service, error) {
+	newReplayFilter := options.ReplayFilter
+	if newReplayFilter == nil {
+		newReplayFilter = func() replay.Filter { return replay.NewCuckoo(60) }
+	}
+
+	paddingPolicy := options.PaddingPolicy
+	if paddingPolicy == nil {
+		paddingPolicy = NoPadding()
+	}
+
 	s := &Service{
-		name:         method,
-		psk:          psk,
-		secureRNG:    secureRNG,
-		replayFilter: replay.NewCuckoo(60),
-		handler:      handler,
-		sessions:     cache.NewLRU[uint64, *serverUDPSession](udpTimeout, true),
+		name:            method,
+		psk:             psk,
+		secureRNG:       options.SecureRNG,
+		replayFilter:    newReplayFilter(),
+		handler:         options.Handler,
+		sessions:        cache.NewLRU[uint64, *serverUDPSession](options.UDPTimeout, true),
+		trafficMeter:    options.TrafficMeter,
+		rateLimiter:     options.RateLimiter,
+		maxSessionPeers: options.MaxSessionPeers,
+		sessionPeerTTL:  options.SessionPeerTTL,
+		paddingPolicy:   paddingPolicy,
 	}
 
 	if len(psk) != KeySaltSize {
@@ -70,25 +147,71 @@ func NewService(method string, psk []byte, secureRNG io.Reader, udpTimeout int64
 	case "2022-blake3-chacha20-poly1305":
 		s.keyLength = 32
 		s.constructor = newChacha20Poly1305
+		s.udpConstructor = newXChacha20Poly1305
 		s.udpCipher = newXChacha20Poly1305(s.psk)
 	}
 
-	s.udpNat = udpnat.New[uint64](udpTimeout, s)
+	s.udpNat = udpnat.New[uint64](options.UDPTimeout, s)
 	return s, nil
 }
 
 func (s *Service) NewConnection(ctx context.Context, conn net.Conn, metadata M.Metadata) error {
+	requestSalt, err := s.readRequestSalt(conn)
+	if err != nil {
+		return err
+	}
+	metadata.Protocol = "shadowsocks"
+	return s.newConnection(ctx, conn, metadata, requestSalt, s.psk, "")
+}
+
+// readRequestSalt reads the per-request salt shared by every user of the
+// service and rejects it if it has already been seen within the replay
+// window.
+func (s *Service) readRequestSalt(conn net.Conn) ([]byte, error) {
 	requestSalt := make([]byte, KeySaltSize)
 	_, err := io.ReadFull(conn, requestSalt)
 	if err != nil {
-		return E.Cause(err, "read request salt")
+		return nil, E.Cause(err, "read request salt")
 	}
 
 	if !s.replayFilter.Check(requestSalt) {
-		return E.New("salt not unique")
+		// The user isn't resolved yet for multi-user services at this
+		// point, so the hit is recorded against the service as a whole.
+		if meter := s.meter(""); meter != nil {
+			meter.AddReplayHit()
+		}
+		return nil, E.New("salt not unique")
+	}
+	return requestSalt, nil
+}
+
+// meter returns the TrafficMeter to report user's traffic to, or nil if
+// ServiceOptions.TrafficMeter wasn't set.
+func (s *Service) meter(user string) trafficcontrol.TrafficMeter {
+	if s.trafficMeter == nil {
+		return nil
+	}
+	return s.trafficMeter(user)
+}
+
+// recordDecryptFailure reports a decrypt or validation failure against
+// user's handshake to the abuse-detection feed, if ServiceOptions.
+// TrafficMeter was set.
+func (s *Service) recordDecryptFailure(user string) {
+	if meter := s.meter(user); meter != nil {
+		meter.AddDecryptFailure()
+	}
+}
+
+// newConnection finishes handshaking a connection once the request salt has
+// been read and the pre-shared key to use has been resolved (either the
+// service-wide PSK, or a per-user PSK resolved through EIH by MultiService).
+func (s *Service) newConnection(ctx context.Context, conn net.Conn, metadata M.Metadata, requestSalt []byte, psk []byte, user string) error {
+	if s.rateLimiter != nil && !s.rateLimiter.Allow(user, metadata.Source.AddrPort().Addr()) {
+		return E.New("rate limited")
 	}
 
-	requestKey := Blake3DeriveKey(s.psk, requestSalt, s.keyLength)
+	requestKey := Blake3DeriveKey(psk, requestSalt, s.keyLength)
 	reader := shadowaead.NewReader(
 		conn,
 		s.constructor(common.Dup(requestKey)),
@@ -97,47 +220,56 @@ func (s *Service) NewConnection(ctx context.Context, conn net.Conn, metadata M.M
 
 	headerType, err := rw.ReadByte(reader)
 	if err != nil {
+		s.recordDecryptFailure(user)
 		return E.Cause(err, "read header")
 	}
 
 	if headerType != HeaderTypeClient {
+		s.recordDecryptFailure(user)
 		return ErrBadHeaderType
 	}
 
 	var epoch uint64
 	err = binary.Read(reader, binary.BigEndian, &epoch)
 	if err != nil {
+		s.recordDecryptFailure(user)
 		return E.Cause(err, "read timestamp")
 	}
 	if math.Abs(float64(time.Now().Unix()-int64(epoch))) > 30 {
+		s.recordDecryptFailure(user)
 		return ErrBadTimestamp
 	}
 
 	destination, err := socks.AddressSerializer.ReadAddrPort(reader)
 	if err != nil {
+		s.recordDecryptFailure(user)
 		return E.Cause(err, "read destination")
 	}
 
 	var paddingLen uint16
 	err = binary.Read(reader, binary.BigEndian, &paddingLen)
 	if err != nil {
+		s.recordDecryptFailure(user)
 		return E.Cause(err, "read padding length")
 	}
 
 	if paddingLen > 0 {
 		err = reader.Discard(int(paddingLen))
 		if err != nil {
+			s.recordDecryptFailure(user)
 			return E.Cause(err, "discard padding")
 		}
 	}
 
-	metadata.Protocol = "shadowsocks"
 	metadata.Destination = destination
+	metadata.User = user
 	return s.handler.NewConnection(ctx, &serverConn{
 		Service:     s,
 		Conn:        conn,
 		reader:      reader,
 		requestSalt: requestSalt,
+		psk:         psk,
+		meter:       s.meter(user),
 	}, metadata)
 }
 
@@ -148,6 +280,8 @@ type serverConn struct {
 	reader      *shadowaead.Reader
 	writer      *shadowaead.Writer
 	requestSalt []byte
+	psk         []byte
+	meter       trafficcontrol.TrafficMeter
 }
 
 func (c *serverConn) writeResponse(payload []byte) (n int, err error) {
@@ -187,6 +321,14 @@ func (c *serverConn) writeResponse(payload []byte) (n int, err error) {
 }
 
 func (c *serverConn) Write(p []byte) (n int, err error) {
+	n, err = c.write(p)
+	if c.meter != nil && n > 0 {
+		c.meter.AddDownload(int64(n))
+	}
+	return
+}
+
+func (c *serverConn) write(p []byte) (n int, err error) {
 	if c.writer != nil {
 		return c.writer.Write(p)
 	}
@@ -201,26 +343,48 @@ func (c *serverConn) Write(p []byte) (n int, err error) {
 
 func (c *serverConn) ReadFrom(r io.Reader) (n int64, err error) {
 	if c.writer == nil {
+		// Falls back through Write above, which already meters.
 		return rw.ReadFrom0(c, r)
 	}
-	return c.writer.ReadFrom(r)
+	n, err = c.writer.ReadFrom(r)
+	if c.meter != nil && n > 0 {
+		c.meter.AddDownload(n)
+	}
+	return
 }
 
 func (c *serverConn) WriteTo(w io.Writer) (n int64, err error) {
-	return c.reader.WriteTo(w)
+	n, err = c.reader.WriteTo(w)
+	if c.meter != nil && n > 0 {
+		c.meter.AddUpload(n)
+	}
+	return
 }
 
 func (s *Service) NewPacket(conn socks.PacketConn, buffer *buf.Buffer, metadata M.Metadata) error {
+	return s.newPacket(conn, buffer, metadata, s.psk, s.udpBlockCipher, s.udpCipher, "")
+}
+
+// newPacket finishes handling an inbound UDP packet once the pre-shared key
+// and top-level header ciphers to use for it have been resolved (either the
+// service-wide values, or per-user values resolved through EIH by
+// MultiService).
+func (s *Service) newPacket(conn socks.PacketConn, buffer *buf.Buffer, metadata M.Metadata, psk []byte, udpBlockCipher cipher.Block, udpCipher cipher.AEAD, user string) error {
+	payloadLen := buffer.Len()
+
 	var packetHeader []byte
-	if s.udpCipher != nil {
-		_, err := s.udpCipher.Open(buffer.Index(PacketNonceSize), buffer.To(PacketNonceSize), buffer.From(PacketNonceSize), nil)
+	if udpCipher != nil {
+		_, err := udpCipher.Open(buffer.Index(PacketNonceSize), buffer.To(PacketNonceSize), buffer.From(PacketNonceSize), nil)
 		if err != nil {
+			if meter := s.meter(user); meter != nil {
+				meter.AddDecryptFailure()
+			}
 			return E.Cause(err, "decrypt packet header")
 		}
 		buffer.Advance(PacketNonceSize)
 	} else {
 		packetHeader = buffer.To(aes.BlockSize)
-		s.udpBlockCipher.Decrypt(packetHeader, packetHeader)
+		udpBlockCipher.Decrypt(packetHeader, packetHeader)
 	}
 
 	var sessionId, packetId uint64
@@ -233,15 +397,21 @@ func (s *Service) NewPacket(conn socks.PacketConn, buffer *buf.Buffer, metadata
 		return err
 	}
 
-	session, loaded := s.sessions.LoadOrStore(sessionId, s.newUDPSession)
+	session, loaded := s.sessions.LoadOrStore(sessionId, func() *serverUDPSession {
+		return s.newUDPSession(psk, udpBlockCipher, udpCipher, user)
+	})
 	if !loaded {
 		session.remoteSessionId = sessionId
 		if packetHeader != nil {
-			key := Blake3DeriveKey(s.psk, packetHeader[:8], s.keyLength)
+			key := Blake3DeriveKey(psk, packetHeader[:8], s.keyLength)
 			session.remoteCipher = s.constructor(common.Dup(key))
 		}
 	}
 	session.remoteAddr = metadata.Source.AddrPort()
+	if !session.peers.touch(session.remoteAddr) {
+		err = E.New("too many distinct remote peers for session")
+		goto returnErr
+	}
 
 	goto process
 
@@ -249,6 +419,13 @@ returnErr:
 	if !loaded {
 		s.sessions.Delete(sessionId)
 	}
+	if meter := s.meter(user); meter != nil {
+		if errors.Is(err, ErrPacketIdNotUnique) {
+			meter.AddReplayHit()
+		} else {
+			meter.AddDecryptFailure()
+		}
+	}
 	return err
 
 process:
@@ -299,6 +476,12 @@ process:
 		goto returnErr
 	}
 	metadata.Destination = destination
+	metadata.User = user
+
+	if meter := s.meter(user); meter != nil {
+		meter.AddUpload(int64(payloadLen))
+		meter.AddUploadPackets(1)
+	}
 
 	s.udpNat.NewPacket(sessionId, func() socks.PacketWriter {
 		return &serverPacketWriter{s, conn, session, metadata.Source}
@@ -314,48 +497,153 @@ type serverPacketWriter struct {
 }
 
 func (w *serverPacketWriter) WritePacket(buffer *buf.Buffer, destination *M.AddrPort) error {
+	payloadLen := buffer.Len()
+	if !w.allow(payloadLen) {
+		buffer.Release()
+		return E.New("rate limited")
+	}
+	header, err := w.encode(buffer, destination)
+	if err != nil {
+		return err
+	}
+	err = w.PacketConn.WritePacket(header, w.source)
+	if err == nil {
+		w.record(payloadLen)
+	}
+	return err
+}
+
+// allow consults the service's RateLimiter, if any, for n additional bytes
+// to the session's user from its remote peer.
+func (w *serverPacketWriter) allow(n int) bool {
+	if w.rateLimiter == nil {
+		return true
+	}
+	return w.rateLimiter.AllowN(w.session.user, w.session.remoteAddr.Addr(), n)
+}
+
+// record reports n bytes and one packet written to the session's user, if
+// ServiceOptions.TrafficMeter was set.
+func (w *serverPacketWriter) record(n int) {
+	meter := w.meter(w.session.user)
+	if meter == nil {
+		return
+	}
+	meter.AddDownload(int64(n))
+	meter.AddDownloadPackets(1)
+}
+
+// WritePackets encodes every buffer in the batch and flushes them together
+// when the underlying PacketConn supports N.MultiPacketWriter (e.g. via
+// sendmmsg on Linux, through bufio.NewVectorisedPacketWriter), falling back
+// to one WritePacket call per buffer otherwise. Every packet in the batch
+// goes to the same session peer, so the batch is addressed with w.source
+// repeated once per buffer.
+func (w *serverPacketWriter) WritePackets(buffers buf.MultiBuffer, destinations []*M.AddrPort) error {
+	payloadLens := make([]int, len(buffers))
+	for index, buffer := range buffers {
+		payloadLens[index] = buffer.Len()
+		if !w.allow(payloadLens[index]) {
+			buffers.Release()
+			return E.New("rate limited")
+		}
+		header, err := w.encode(buffer, destinations[index])
+		if err != nil {
+			// encode already released buffer via its own defer, so it must
+			// be skipped here to avoid releasing it a second time.
+			for _, b := range buffers[:index] {
+				b.Release()
+			}
+			for _, b := range buffers[index+1:] {
+				b.Release()
+			}
+			return err
+		}
+		buffers[index] = header
+	}
+	if multiWriter, isMulti := w.PacketConn.(N.MultiPacketWriter); isMulti {
+		wireDestinations := make([]M.Socksaddr, len(buffers))
+		destination := socksaddrFromAddrPort(w.source)
+		for index := range wireDestinations {
+			wireDestinations[index] = destination
+		}
+		err := multiWriter.WritePackets(buffers, wireDestinations)
+		if err == nil {
+			for _, n := range payloadLens {
+				w.record(n)
+			}
+		}
+		return err
+	}
+	defer buffers.Release()
+	for index, buffer := range buffers {
+		err := w.PacketConn.WritePacket(buffer, w.source)
+		if err != nil {
+			return err
+		}
+		w.record(payloadLens[index])
+	}
+	return nil
+}
+
+// socksaddrFromAddrPort adapts this package's *M.AddrPort session address
+// to the M.Socksaddr used by the generic N.MultiPacketWriter batching path.
+func socksaddrFromAddrPort(addr *M.AddrPort) M.Socksaddr {
+	return M.SocksaddrFromNetIP(netip.AddrPortFrom(addr.Addr(), addr.Port()))
+}
+
+// encode seals buffer into a complete, ready-to-send session packet
+// addressed to destination, releasing buffer and returning the new buffer
+// that owns the wire bytes.
+func (w *serverPacketWriter) encode(buffer *buf.Buffer, destination *M.AddrPort) (*buf.Buffer, error) {
 	defer buffer.Release()
 
 	_header := buf.StackNew()
 	header := common.Dup(_header)
 
 	var dataIndex int
-	if w.udpCipher != nil {
+	if w.session.udpCipher != nil {
 		common.Must1(header.ReadFullFrom(w.secureRNG, PacketNonceSize))
 		dataIndex = buffer.Len()
 	} else {
 		dataIndex = aes.BlockSize
 	}
 
+	paddingLength := w.paddingPolicy.PaddingLength(destination.Port(), buffer.Len())
+
 	common.Must(
 		binary.Write(header, binary.BigEndian, w.session.sessionId),
 		binary.Write(header, binary.BigEndian, w.session.nextPacketId()),
 		header.WriteByte(HeaderTypeServer),
 		binary.Write(header, binary.BigEndian, uint64(time.Now().Unix())),
 		binary.Write(header, binary.BigEndian, w.session.remoteSessionId),
-		binary.Write(header, binary.BigEndian, uint16(0)), // padding length
+		binary.Write(header, binary.BigEndian, uint16(paddingLength)),
 	)
 
+	if paddingLength > 0 {
+		common.Must1(header.ReadFullFrom(w.secureRNG, paddingLength))
+	}
+
 	err := socks.AddressSerializer.WriteAddrPort(header, destination)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	_, err = header.Write(buffer.Bytes())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if w.udpCipher != nil {
-		w.udpCipher.Seal(header.Index(dataIndex), header.To(dataIndex), header.From(dataIndex), nil)
-		header.Extend(w.udpCipher.Overhead())
+	if w.session.udpCipher != nil {
+		w.session.udpCipher.Seal(header.Index(dataIndex), header.To(dataIndex), header.From(dataIndex), nil)
+		header.Extend(w.session.udpCipher.Overhead())
 	} else {
 		packetHeader := header.To(aes.BlockSize)
 		w.session.cipher.Seal(header.Index(dataIndex), packetHeader[4:16], header.From(dataIndex), nil)
 		header.Extend(w.session.cipher.Overhead())
-		w.udpBlockCipher.Encrypt(packetHeader, packetHeader)
+		w.session.udpBlockCipher.Encrypt(packetHeader, packetHeader)
 	}
-	return w.PacketConn.WritePacket(header, w.source)
+	return header, nil
 }
 
 type serverUDPSession struct {
@@ -366,29 +654,61 @@ type serverUDPSession struct {
 	cipher          cipher.AEAD
 	remoteCipher    cipher.AEAD
 	filter          wgReplay.Filter
+	user            string
+	udpBlockCipher  cipher.Block
+	udpCipher       cipher.AEAD
+	peers           *peerTracker
 }
 
 func (s *serverUDPSession) nextPacketId() uint64 {
 	return atomic.AddUint64(&s.packetId, 1)
 }
 
-func (m *Service) newUDPSession() *serverUDPSession {
-	session := &serverUDPSession{}
+// newUDPSession creates a new session bound to the resolved user's PSK and
+// top-level header ciphers. For the default (single-user) service these are
+// simply the service-wide psk/udpBlockCipher/udpCipher and an empty user.
+func (m *Service) newUDPSession(psk []byte, udpBlockCipher cipher.Block, udpCipher cipher.AEAD, user string) *serverUDPSession {
+	session := &serverUDPSession{
+		user:           user,
+		udpBlockCipher: udpBlockCipher,
+		udpCipher:      udpCipher,
+		peers:          newPeerTracker(m.maxSessionPeers, m.sessionPeerTTL),
+	}
 	common.Must(binary.Read(m.secureRNG, binary.BigEndian, &session.sessionId))
 	session.packetId--
-	if m.udpCipher == nil {
+	if udpCipher == nil {
 		sessionId := make([]byte, 8)
 		binary.BigEndian.PutUint64(sessionId, session.sessionId)
-		key := Blake3DeriveKey(m.psk, sessionId, m.keyLength)
+		key := Blake3DeriveKey(psk, sessionId, m.keyLength)
 		session.cipher = m.constructor(common.Dup(key))
 	}
 	return session
 }
 
 func (s *Service) NewPacketConnection(conn socks.PacketConn, metadata M.Metadata) error {
+	if s.rateLimiter != nil && !s.rateLimiter.Allow(metadata.User, metadata.Source.AddrPort().Addr()) {
+		return E.New("rate limited")
+	}
+	// Best-effort: if the transport exposes its raw *net.UDPConn, turn on
+	// kernel path MTU discovery so a later N.MTUProber.PathMTU() call (e.g.
+	// from N.CalculateMTU) can report a real measurement instead of a
+	// conservative guess, letting CopyPacket size its buffer accordingly.
+	if rawConn, ok := conn.(interface{ UDPConn() *net.UDPConn }); ok {
+		_ = N.EnablePathMTUDiscovery(rawConn.UDPConn())
+	}
 	return s.handler.NewPacketConnection(conn, metadata)
 }
 
 func (s *Service) HandleError(err error) {
 	s.handler.HandleError(err)
+}
+
+// Stats reports the replay filter's current fill level and estimated
+// false-positive rate, letting operators alert on saturation before it
+// starts rejecting non-replayed requests. ok is false if the configured
+// replay.Filter doesn't implement replay.StatsFilter (e.g. the default
+// cuckoo filter).
+func (s *Service) Stats() (stats replay.StatsFilter, ok bool) {
+	stats, ok = s.replayFilter.(replay.StatsFilter)
+	return
 }
\ No newline at end of file
@@ -0,0 +1,66 @@
+package shadowaead_2022
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// defaultMaxSessionPeers and defaultSessionPeerTTL are used when
+// ServiceOptions leaves MaxSessionPeers/SessionPeerTTL unset.
+const (
+	defaultMaxSessionPeers = 8
+	defaultSessionPeerTTL  = 5 * time.Minute
+)
+
+// peerTracker bounds the number of distinct remote AddrPorts a single UDP
+// session accepts packets from, expiring entries once they age past the TTL
+// so a stale source can later be displaced. Unlike an LRU cache, reaching
+// the cap actually rejects a new, not-yet-seen peer instead of silently
+// evicting the oldest one -- the cap is enforced, not just tracked.
+type peerTracker struct {
+	access sync.Mutex
+	cap    int
+	ttl    time.Duration
+	seen   map[netip.AddrPort]time.Time
+}
+
+func newPeerTracker(cap int, ttl time.Duration) *peerTracker {
+	if cap <= 0 {
+		cap = defaultMaxSessionPeers
+	}
+	if ttl <= 0 {
+		ttl = defaultSessionPeerTTL
+	}
+	return &peerTracker{cap: cap, ttl: ttl, seen: make(map[netip.AddrPort]time.Time, cap)}
+}
+
+// touch records addr as seen just now and reports whether the session
+// should keep processing its packet. A previously-seen peer is always
+// allowed and has its last-seen time refreshed. A new peer is allowed only
+// if, after expiring entries older than ttl, the session has room under cap
+// -- otherwise it's rejected and not added, leaving the existing peers in
+// place.
+func (t *peerTracker) touch(addr netip.AddrPort) bool {
+	t.access.Lock()
+	defer t.access.Unlock()
+
+	now := time.Now()
+	if _, loaded := t.seen[addr]; loaded {
+		t.seen[addr] = now
+		return true
+	}
+
+	for peer, last := range t.seen {
+		if now.Sub(last) > t.ttl {
+			delete(t.seen, peer)
+		}
+	}
+
+	if len(t.seen) >= t.cap {
+		return false
+	}
+
+	t.seen[addr] = now
+	return true
+}